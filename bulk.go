@@ -1,6 +1,8 @@
 package mgo
 
 import (
+	"fmt"
+
 	"gopkg.in/mgo.v2-unstable/bson"
 )
 
@@ -9,8 +11,7 @@ import (
 //
 // Relevant documentation:
 //
-//   http://blog.mongodb.org/post/84922794768/mongodbs-new-bulk-api
-//
+//	http://blog.mongodb.org/post/84922794768/mongodbs-new-bulk-api
 type Bulk struct {
 	c       *Collection
 	ordered bool
@@ -23,6 +24,7 @@ const (
 	bulkInsert bulkOp = iota + 1
 	bulkUpdate
 	bulkUpdateAll
+	bulkDelete
 )
 
 type bulkAction struct {
@@ -32,30 +34,62 @@ type bulkAction struct {
 
 type bulkUpdateOp []interface{}
 
-// BulkError holds an error returned from running a Bulk operation.
-//
-// TODO: This is private for the moment, until we understand exactly how
-//       to report these multi-errors in a useful and convenient way.
-type bulkError struct {
-	err error
+// BulkUpsert reports that an upserted update created a new document,
+// identified by its index in the queued operations and its _id.
+type BulkUpsert struct {
+	Index int
+	Id    interface{}
+}
+
+// BulkErrorCase holds an individual failure within a Bulk.Run call,
+// identified by the index the failing operation had among all the
+// operations queued on the Bulk, in the order they were added.
+type BulkErrorCase struct {
+	Index int
+	Err   error
+}
+
+// BulkError holds the individual errors returned from running a Bulk
+// operation. Callers that need to tell apart duplicate-key failures from
+// other kinds of failures should inspect Cases, or use IsDup(err).
+type BulkError struct {
+	ecases []BulkErrorCase
+}
+
+func (e *BulkError) Error() string {
+	if len(e.ecases) == 0 {
+		return "invalid BulkError instance: no errors"
+	}
+	msg := e.ecases[0].Err.Error()
+	if len(e.ecases) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.ecases)-1)
+	}
+	return msg
+}
+
+// Cases returns every individual failure recorded in e, each tagged with
+// the index of the failing operation among all operations queued on the
+// Bulk that produced it.
+func (e *BulkError) Cases() []BulkErrorCase {
+	return e.ecases
 }
 
 // BulkResult holds the results for a bulk operation.
 type BulkResult struct {
+	Matched  int
+	Modified int // Not available for servers before MongoDB 2.6.
+	Inserted int
+	Upserted []BulkUpsert
+
 	// Be conservative while we understand exactly how to report these
 	// results in a useful and convenient way, and also how to emulate
 	// them with prior servers.
 	private bool
 }
 
-func (e *bulkError) Error() string {
-	return e.err.Error()
-}
-
 // Bulk returns a value to prepare the execution of a bulk operation.
 //
 // WARNING: This API is still experimental.
-//
 func (c *Collection) Bulk() *Bulk {
 	return &Bulk{c: c, ordered: true}
 }
@@ -84,7 +118,6 @@ func (b *Bulk) action(op bulkOp) *bulkAction {
 	return &b.actions[len(b.actions)-1]
 }
 
-
 // Insert queues up the provided documents for insertion.
 func (b *Bulk) Insert(docs ...interface{}) {
 	action := b.action(bulkInsert)
@@ -107,8 +140,8 @@ func (b *Bulk) Update(pairs ...interface{}) {
 		}
 		action.docs = append(action.docs, &updateOp{
 			Collection: b.c.FullName,
-			Selector: selector,
-			Update: pairs[i+1],
+			Selector:   selector,
+			Update:     pairs[i+1],
 		})
 	}
 }
@@ -129,10 +162,10 @@ func (b *Bulk) UpdateAll(pairs ...interface{}) {
 		}
 		action.docs = append(action.docs, &updateOp{
 			Collection: b.c.FullName,
-			Selector: selector,
-			Update: pairs[i+1],
-			Flags: 2,
-			Multi: true,
+			Selector:   selector,
+			Update:     pairs[i+1],
+			Flags:      2,
+			Multi:      true,
 		})
 	}
 }
@@ -140,16 +173,17 @@ func (b *Bulk) UpdateAll(pairs ...interface{}) {
 // Run runs all the operations queued up.
 func (b *Bulk) Run() (*BulkResult, error) {
 	var result BulkResult
-	var berr bulkError
+	var berr BulkError
 	var failed bool
+	index := 0
 	for i := range b.actions {
 		action := &b.actions[i]
 		var ok bool
 		switch action.op {
 		case bulkInsert:
-			ok = b.runInsert(action, &result, &berr)
+			ok = b.runInsert(action, &result, &berr, &index)
 		case bulkUpdate:
-			ok = b.runUpdate(action, &result, &berr)
+			ok = b.runUpdate(action, &result, &berr, &index)
 		default:
 			panic("unknown bulk operation")
 		}
@@ -166,31 +200,54 @@ func (b *Bulk) Run() (*BulkResult, error) {
 	return &result, nil
 }
 
-func (b *Bulk) runInsert(action *bulkAction, result *BulkResult, berr *bulkError) bool {
+func (b *Bulk) runInsert(action *bulkAction, result *BulkResult, berr *BulkError, index *int) bool {
 	op := &insertOp{b.c.FullName, action.docs, 0}
 	if !b.ordered {
 		op.flags = 1 // ContinueOnError
 	}
+	base := *index
+	*index += len(action.docs)
+
 	_, err := b.c.writeOp(op, b.ordered)
-	if err != nil {
-		berr.err = err
-		return false
+	if err == nil {
+		result.Inserted += len(action.docs)
+		return true
+	}
+	if inner, ok := err.(*BulkError); ok {
+		for _, c := range inner.ecases {
+			berr.ecases = append(berr.ecases, BulkErrorCase{Index: base + c.Index, Err: c.Err})
+		}
+	} else {
+		berr.ecases = append(berr.ecases, BulkErrorCase{Index: base, Err: err})
 	}
-	return true
+	return false
 }
 
-func (b *Bulk) runUpdate(action *bulkAction, result *BulkResult, berr *bulkError) bool {
+func (b *Bulk) runUpdate(action *bulkAction, result *BulkResult, berr *BulkError, index *int) bool {
 	ok := true
 	for _, op := range action.docs {
-		_, err := b.c.writeOp(op, b.ordered)
+		i := *index
+		*index++
+		lerr, err := b.c.writeOp(op, b.ordered)
 		if err != nil {
 			ok = false
-			berr.err = &bulkError{err}
+			berr.ecases = append(berr.ecases, BulkErrorCase{Index: i, Err: err})
 			if b.ordered {
 				break
 			}
+			continue
+		}
+		if lerr == nil {
+			continue
+		}
+		if lerr.UpsertedId != nil {
+			result.Upserted = append(result.Upserted, BulkUpsert{Index: i, Id: lerr.UpsertedId})
+		} else {
+			result.Matched += lerr.N
+			if lerr.UpdatedExisting {
+				result.Modified += lerr.N
+			}
 		}
-		// TODO Report number of updates into result.
 	}
 	return ok
 }
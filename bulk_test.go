@@ -0,0 +1,46 @@
+package mgo
+
+import "testing"
+
+func TestIsDupPlainErrors(t *testing.T) {
+	if !IsDup(&LastError{Code: 11000}) {
+		t.Fatal("expected LastError with code 11000 to be a dup")
+	}
+	if !IsDup(&QueryError{Code: 11001}) {
+		t.Fatal("expected QueryError with code 11001 to be a dup")
+	}
+	if IsDup(&QueryError{Code: 17}) {
+		t.Fatal("expected QueryError with an unrelated code not to be a dup")
+	}
+}
+
+func TestIsDupBulkError(t *testing.T) {
+	allDup := &BulkError{ecases: []BulkErrorCase{
+		{Index: 0, Err: &LastError{Code: 11000}},
+		{Index: 1, Err: &LastError{Code: 11001}},
+	}}
+	if !IsDup(allDup) {
+		t.Fatal("expected a BulkError whose every case is a dup to be a dup")
+	}
+
+	mixed := &BulkError{ecases: []BulkErrorCase{
+		{Index: 0, Err: &LastError{Code: 11000}},
+		{Index: 1, Err: &LastError{Code: 17}},
+	}}
+	if IsDup(mixed) {
+		t.Fatal("expected a BulkError with a non-dup case not to be a dup")
+	}
+
+	if IsDup(&BulkError{}) {
+		t.Fatal("expected an empty BulkError not to be a dup")
+	}
+}
+
+func TestBulkErrorCases(t *testing.T) {
+	cases := []BulkErrorCase{{Index: 2, Err: &LastError{Code: 11000}}}
+	err := &BulkError{ecases: cases}
+	got := err.Cases()
+	if len(got) != 1 || got[0].Index != 2 {
+		t.Fatalf("Cases() = %+v, want %+v", got, cases)
+	}
+}
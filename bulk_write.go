@@ -0,0 +1,458 @@
+package mgo
+
+import "gopkg.in/mgo.v2-unstable/bson"
+
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// BulkWrite sends a batch of heterogeneous write operations, possibly
+// spanning several collections, modeled after the client bulk write added to
+// newer drivers. Unlike Collection.Bulk, which is scoped to a single
+// collection, models here each carry their own Namespace.
+//
+// Consecutive models that share the same namespace and operation type are
+// grouped together and sent to the server as a single insert/update/delete
+// command per wire batch, rather than one round trip per model, but the
+// original index of every model is preserved so per-operation errors can be
+// reported back against it. In ordered mode (the default) processing stops
+// at the first failing group; in unordered mode every group runs and all
+// errors are collected.
+//
+// maxWriteBatchSize and maxBsonObjectSize bound how large a single group is
+// allowed to grow before it is split into multiple wire batches.
+const (
+	maxWriteBatchSize = 100000
+	maxBsonObjectSize = 16 * 1024 * 1024
+)
+
+// Namespace identifies the database and collection a WriteModel applies to.
+type Namespace struct {
+	DB         string
+	Collection string
+}
+
+// WriteModel is implemented by the InsertOneModel, UpdateOneModel,
+// UpdateManyModel, ReplaceOneModel, DeleteOneModel and DeleteManyModel
+// types, each describing one operation to run via Session.BulkWrite.
+type WriteModel interface {
+	namespace() Namespace
+	bulkOpType() bulkOp
+}
+
+// InsertOneModel requests the insertion of a single document.
+type InsertOneModel struct {
+	NS       Namespace
+	Document interface{}
+}
+
+func (m InsertOneModel) namespace() Namespace { return m.NS }
+func (m InsertOneModel) bulkOpType() bulkOp   { return bulkInsert }
+
+// UpdateOneModel requests that at most one document matching Filter be
+// updated according to Update.
+type UpdateOneModel struct {
+	NS     Namespace
+	Filter interface{}
+	Update interface{}
+	Upsert bool
+}
+
+func (m UpdateOneModel) namespace() Namespace { return m.NS }
+func (m UpdateOneModel) bulkOpType() bulkOp   { return bulkUpdate }
+
+// UpdateManyModel requests that every document matching Filter be updated
+// according to Update.
+type UpdateManyModel struct {
+	NS     Namespace
+	Filter interface{}
+	Update interface{}
+	Upsert bool
+}
+
+func (m UpdateManyModel) namespace() Namespace { return m.NS }
+func (m UpdateManyModel) bulkOpType() bulkOp   { return bulkUpdate }
+
+// ReplaceOneModel requests that at most one document matching Filter be
+// replaced entirely by Replacement.
+type ReplaceOneModel struct {
+	NS          Namespace
+	Filter      interface{}
+	Replacement interface{}
+	Upsert      bool
+}
+
+func (m ReplaceOneModel) namespace() Namespace { return m.NS }
+func (m ReplaceOneModel) bulkOpType() bulkOp   { return bulkUpdate }
+
+// DeleteOneModel requests the removal of at most one document matching
+// Filter.
+type DeleteOneModel struct {
+	NS     Namespace
+	Filter interface{}
+}
+
+func (m DeleteOneModel) namespace() Namespace { return m.NS }
+func (m DeleteOneModel) bulkOpType() bulkOp   { return bulkDelete }
+
+// DeleteManyModel requests the removal of every document matching Filter.
+type DeleteManyModel struct {
+	NS     Namespace
+	Filter interface{}
+}
+
+func (m DeleteManyModel) namespace() Namespace { return m.NS }
+func (m DeleteManyModel) bulkOpType() bulkOp   { return bulkDelete }
+
+// BulkWriteOptions holds the options accepted by Session.BulkWrite.
+type BulkWriteOptions struct {
+	// Ordered defaults to true: processing stops at the first error.
+	// Set to a false pointer to continue past failures.
+	Ordered *bool
+
+	// BypassDocumentValidation disables schema validation for this batch.
+	BypassDocumentValidation bool
+
+	// Let supplies variables that can be referenced from Filter/Update
+	// documents using "$$variable" syntax.
+	Let interface{}
+}
+
+func (opts *BulkWriteOptions) ordered() bool {
+	return opts == nil || opts.Ordered == nil || *opts.Ordered
+}
+
+// BulkWriteError reports the failure of a single operation within a
+// Session.BulkWrite call, identified by its original index in the models
+// slice passed in.
+type BulkWriteError struct {
+	Index   int
+	Code    int
+	Message string
+	Details []byte
+}
+
+func (e BulkWriteError) Error() string {
+	return e.Message
+}
+
+// BulkWriteException is returned by Session.BulkWrite whenever one or more
+// operations failed. It satisfies the error interface so callers that don't
+// care about individual failures can still just check err != nil.
+type BulkWriteException struct {
+	WriteErrors       []BulkWriteError
+	WriteConcernError error
+}
+
+func (e *BulkWriteException) Error() string {
+	if len(e.WriteErrors) == 1 {
+		return e.WriteErrors[0].Message
+	}
+	return "multiple errors in bulk write"
+}
+
+// BulkWriteResult reports aggregate counters across every operation
+// performed by a Session.BulkWrite call.
+type BulkWriteResult struct {
+	InsertedCount int
+	MatchedCount  int
+	ModifiedCount int
+	DeletedCount  int
+	UpsertedCount int
+	UpsertedIDs   map[int]interface{}
+}
+
+// writeGroup is a run of consecutive models sharing both namespace and
+// operation type, along with the index each model had in the original
+// models slice, so per-op errors can be attributed correctly.
+type writeGroup struct {
+	ns      Namespace
+	op      bulkOp
+	models  []WriteModel
+	indexes []int
+}
+
+func groupWriteModels(models []WriteModel) []writeGroup {
+	var groups []writeGroup
+	for i, m := range models {
+		ns, op := m.namespace(), m.bulkOpType()
+		if n := len(groups); n > 0 && groups[n-1].ns == ns && groups[n-1].op == op {
+			groups[n-1].models = append(groups[n-1].models, m)
+			groups[n-1].indexes = append(groups[n-1].indexes, i)
+			continue
+		}
+		groups = append(groups, writeGroup{ns: ns, op: op, models: []WriteModel{m}, indexes: []int{i}})
+	}
+	return groups
+}
+
+// nextBatchEnd returns the end of the next wire batch starting at
+// models[start], bounded by both maxWriteBatchSize (document count) and
+// maxBsonObjectSize (encoded size). It always advances by at least one
+// model, even if that model alone exceeds maxBsonObjectSize, so a single
+// oversized document can't stall the loop.
+func nextBatchEnd(models []WriteModel, start int) int {
+	size := 0
+	end := start
+	for end < len(models) && end-start < maxWriteBatchSize {
+		docSize := modelApproxSize(models[end])
+		if end > start && size+docSize > maxBsonObjectSize {
+			break
+		}
+		size += docSize
+		end++
+	}
+	return end
+}
+
+// modelApproxSize estimates the encoded size of the document(s) a model
+// will contribute to its wire batch, for nextBatchEnd's maxBsonObjectSize
+// accounting. It returns 0 (no effect on batch splitting) if the model
+// can't be marshaled - BulkWrite will still surface the real error when it
+// actually tries to send the batch.
+func modelApproxSize(m WriteModel) int {
+	var doc interface{}
+	switch mm := m.(type) {
+	case InsertOneModel:
+		doc = mm.Document
+	case UpdateOneModel:
+		doc = bson.D{{Name: "q", Value: mm.Filter}, {Name: "u", Value: mm.Update}}
+	case UpdateManyModel:
+		doc = bson.D{{Name: "q", Value: mm.Filter}, {Name: "u", Value: mm.Update}}
+	case ReplaceOneModel:
+		doc = bson.D{{Name: "q", Value: mm.Filter}, {Name: "u", Value: mm.Replacement}}
+	case DeleteOneModel:
+		doc = bson.D{{Name: "q", Value: mm.Filter}}
+	case DeleteManyModel:
+		doc = bson.D{{Name: "q", Value: mm.Filter}}
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// BulkWrite runs models - which may span multiple collections - against the
+// server, batching consecutive same-namespace/same-type operations together
+// while preserving the original index of every model for error reporting.
+//
+// In ordered mode (the default) BulkWrite stops at the first group that
+// fails. In unordered mode every group runs and *BulkWriteException
+// aggregates every failure, still labelled with their original index.
+func (s *Session) BulkWrite(models []WriteModel, opts *BulkWriteOptions) (*BulkWriteResult, error) {
+	result := &BulkWriteResult{UpsertedIDs: map[int]interface{}{}}
+	exc := &BulkWriteException{}
+	ordered := opts.ordered()
+
+	for _, group := range groupWriteModels(models) {
+		c := s.DB(group.ns.DB).C(group.ns.Collection)
+		for batchStart := 0; batchStart < len(group.models); {
+			batchEnd := nextBatchEnd(group.models, batchStart)
+			failed := runWriteGroupBatch(c, group, batchStart, batchEnd, opts, result, exc)
+			if failed && ordered {
+				return result, exc
+			}
+			batchStart = batchEnd
+		}
+	}
+
+	if len(exc.WriteErrors) > 0 || exc.WriteConcernError != nil {
+		return result, exc
+	}
+	return result, nil
+}
+
+// writeCommandResult is the shape of the server's reply to the insert,
+// update and delete commands, as described in the write commands spec.
+type writeCommandResult struct {
+	Ok        bool `bson:"ok"`
+	N         int  `bson:"n"`
+	NModified int  `bson:"nModified"`
+	Upserted  []struct {
+		Index int         `bson:"index"`
+		Id    interface{} `bson:"_id"`
+	} `bson:"upserted"`
+	WriteErrors []struct {
+		Index  int    `bson:"index"`
+		Code   int    `bson:"code"`
+		Errmsg string `bson:"errmsg"`
+	} `bson:"writeErrors"`
+	WriteConcernError *struct {
+		Code   int    `bson:"code"`
+		Errmsg string `bson:"errmsg"`
+	} `bson:"writeConcernError"`
+}
+
+// runWriteGroupBatch sends models[start:end] of group to the server as a
+// single insert/update/delete command - one wire batch, regardless of how
+// many models it holds - honoring opts, and merges the outcome into
+// result/exc. It returns true if the command failed or any operation in the
+// batch reported a write error; a write concern error alone is recorded in
+// exc but doesn't make it return true.
+func runWriteGroupBatch(c *Collection, group writeGroup, start, end int, opts *BulkWriteOptions, result *BulkWriteResult, exc *BulkWriteException) bool {
+	var cmd bson.D
+	switch group.op {
+	case bulkInsert:
+		cmd = insertCommand(c, group.models[start:end], opts)
+	case bulkUpdate:
+		cmd = updateCommand(c, group.models[start:end], opts)
+	case bulkDelete:
+		cmd = deleteCommand(c, group.models[start:end], opts)
+	}
+
+	var reply writeCommandResult
+	err := c.Database.Run(cmd, &reply)
+	if err != nil {
+		failed := true
+		for i := start; i < end; i++ {
+			exc.WriteErrors = append(exc.WriteErrors, bulkWriteErrorFrom(err, group.indexes[i]))
+		}
+		return failed
+	}
+
+	mergeWriteResult(result, group.op, &reply, group.indexes[start:end])
+	failed := false
+	for _, we := range reply.WriteErrors {
+		failed = true
+		idx := group.indexes[start+we.Index]
+		exc.WriteErrors = append(exc.WriteErrors, BulkWriteError{Index: idx, Code: we.Code, Message: we.Errmsg})
+	}
+	if reply.WriteConcernError != nil {
+		exc.WriteConcernError = &QueryError{Code: reply.WriteConcernError.Code, Message: reply.WriteConcernError.Errmsg}
+	}
+	return failed
+}
+
+// mergeWriteResult folds a single batch's reply into the running totals of
+// result. indexes maps the reply's implicit 0..n-1 positions back to the
+// original index each model had in the models slice passed to BulkWrite.
+func mergeWriteResult(result *BulkWriteResult, op bulkOp, reply *writeCommandResult, indexes []int) {
+	switch op {
+	case bulkInsert:
+		result.InsertedCount += reply.N
+	case bulkUpdate:
+		for _, u := range reply.Upserted {
+			result.UpsertedCount++
+			result.UpsertedIDs[indexes[u.Index]] = u.Id
+		}
+		result.MatchedCount += reply.N - len(reply.Upserted)
+		result.ModifiedCount += reply.NModified
+	case bulkDelete:
+		result.DeletedCount += reply.N
+	}
+}
+
+func bulkWriteErrorFrom(err error, idx int) BulkWriteError {
+	if qerr, ok := err.(*QueryError); ok {
+		return BulkWriteError{Index: idx, Code: qerr.Code, Message: qerr.Message}
+	}
+	return BulkWriteError{Index: idx, Message: err.Error()}
+}
+
+// insertCommand builds a single insert command covering every model in
+// models, honoring opts.Ordered and opts.BypassDocumentValidation.
+func insertCommand(c *Collection, models []WriteModel, opts *BulkWriteOptions) bson.D {
+	docs := make([]interface{}, len(models))
+	for i, m := range models {
+		docs[i] = m.(InsertOneModel).Document
+	}
+	cmd := bson.D{
+		{Name: "insert", Value: c.Name},
+		{Name: "documents", Value: docs},
+		{Name: "ordered", Value: opts.ordered()},
+	}
+	return appendCommonOptions(cmd, opts)
+}
+
+// updateCommand builds a single update command covering every model in
+// models, honoring opts.Ordered, opts.BypassDocumentValidation and opts.Let.
+func updateCommand(c *Collection, models []WriteModel, opts *BulkWriteOptions) bson.D {
+	updates := make([]bson.D, len(models))
+	for i, m := range models {
+		spec := bson.D{}
+		switch um := m.(type) {
+		case UpdateOneModel:
+			spec = append(spec, bson.DocElem{Name: "q", Value: um.Filter}, bson.DocElem{Name: "u", Value: um.Update}, bson.DocElem{Name: "upsert", Value: um.Upsert}, bson.DocElem{Name: "multi", Value: false})
+		case UpdateManyModel:
+			spec = append(spec, bson.DocElem{Name: "q", Value: um.Filter}, bson.DocElem{Name: "u", Value: um.Update}, bson.DocElem{Name: "upsert", Value: um.Upsert}, bson.DocElem{Name: "multi", Value: true})
+		case ReplaceOneModel:
+			spec = append(spec, bson.DocElem{Name: "q", Value: um.Filter}, bson.DocElem{Name: "u", Value: um.Replacement}, bson.DocElem{Name: "upsert", Value: um.Upsert}, bson.DocElem{Name: "multi", Value: false})
+		}
+		updates[i] = spec
+	}
+	cmd := bson.D{
+		{Name: "update", Value: c.Name},
+		{Name: "updates", Value: updates},
+		{Name: "ordered", Value: opts.ordered()},
+	}
+	return appendCommonOptions(cmd, opts)
+}
+
+// deleteCommand builds a single delete command covering every model in
+// models, honoring opts.Ordered and opts.Let.
+func deleteCommand(c *Collection, models []WriteModel, opts *BulkWriteOptions) bson.D {
+	deletes := make([]bson.D, len(models))
+	for i, m := range models {
+		limit := 1
+		var filter interface{}
+		switch dm := m.(type) {
+		case DeleteOneModel:
+			filter = dm.Filter
+		case DeleteManyModel:
+			filter, limit = dm.Filter, 0
+		}
+		deletes[i] = bson.D{{Name: "q", Value: filter}, {Name: "limit", Value: limit}}
+	}
+	cmd := bson.D{
+		{Name: "delete", Value: c.Name},
+		{Name: "deletes", Value: deletes},
+		{Name: "ordered", Value: opts.ordered()},
+	}
+	return appendLet(cmd, opts)
+}
+
+// appendCommonOptions adds bypassDocumentValidation and let to cmd when
+// opts requests them, for the insert and update commands (both support
+// bypassDocumentValidation; delete doesn't, see appendLet).
+func appendCommonOptions(cmd bson.D, opts *BulkWriteOptions) bson.D {
+	if opts == nil {
+		return cmd
+	}
+	if opts.BypassDocumentValidation {
+		cmd = append(cmd, bson.DocElem{Name: "bypassDocumentValidation", Value: true})
+	}
+	return appendLet(cmd, opts)
+}
+
+// appendLet adds let to cmd when opts requests it. Unlike
+// bypassDocumentValidation, let is accepted by the insert, update and
+// delete commands alike.
+func appendLet(cmd bson.D, opts *BulkWriteOptions) bson.D {
+	if opts != nil && opts.Let != nil {
+		cmd = append(cmd, bson.DocElem{Name: "let", Value: opts.Let})
+	}
+	return cmd
+}
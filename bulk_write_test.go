@@ -0,0 +1,95 @@
+package mgo
+
+import "testing"
+
+func TestGroupWriteModelsMergesConsecutiveSameTypeSameNamespace(t *testing.T) {
+	ns1 := Namespace{DB: "d", Collection: "c1"}
+	ns2 := Namespace{DB: "d", Collection: "c2"}
+	models := []WriteModel{
+		InsertOneModel{NS: ns1, Document: 1},
+		InsertOneModel{NS: ns1, Document: 2},
+		DeleteOneModel{NS: ns1, Filter: 3},
+		InsertOneModel{NS: ns2, Document: 4},
+	}
+	groups := groupWriteModels(models)
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %d", len(groups))
+	}
+	if len(groups[0].models) != 2 || groups[0].op != bulkInsert {
+		t.Fatalf("expected first group to merge the two ns1 inserts, got %+v", groups[0])
+	}
+	if !reflectEqualInts(groups[0].indexes, []int{0, 1}) {
+		t.Fatalf("expected first group indexes [0 1], got %v", groups[0].indexes)
+	}
+	if groups[1].op != bulkDelete || len(groups[1].models) != 1 {
+		t.Fatalf("expected second group to be the lone delete, got %+v", groups[1])
+	}
+	if groups[2].ns != ns2 {
+		t.Fatalf("expected third group on ns2, got %+v", groups[2])
+	}
+}
+
+func reflectEqualInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNextBatchEndSplitsOnCount(t *testing.T) {
+	models := make([]WriteModel, maxWriteBatchSize+1)
+	for i := range models {
+		models[i] = InsertOneModel{Document: i}
+	}
+	end := nextBatchEnd(models, 0)
+	if end != maxWriteBatchSize {
+		t.Fatalf("expected batch to stop at maxWriteBatchSize (%d), got %d", maxWriteBatchSize, end)
+	}
+}
+
+func TestNextBatchEndSplitsOnSize(t *testing.T) {
+	big := make([]byte, maxBsonObjectSize)
+	models := []WriteModel{
+		InsertOneModel{Document: big},
+		InsertOneModel{Document: "small"},
+	}
+	end := nextBatchEnd(models, 0)
+	if end != 1 {
+		t.Fatalf("expected an oversized model to be alone in its batch, got end=%d", end)
+	}
+}
+
+func TestNextBatchEndAlwaysAdvances(t *testing.T) {
+	models := []WriteModel{InsertOneModel{Document: make([]byte, maxBsonObjectSize*2)}}
+	end := nextBatchEnd(models, 0)
+	if end != 1 {
+		t.Fatalf("expected nextBatchEnd to advance past a single oversized model, got %d", end)
+	}
+}
+
+func TestModelApproxSizeVariants(t *testing.T) {
+	if modelApproxSize(InsertOneModel{Document: map[string]interface{}{"x": 1}}) == 0 {
+		t.Fatal("expected a non-zero size for an InsertOneModel")
+	}
+	if modelApproxSize(UpdateOneModel{Filter: "f", Update: "u"}) == 0 {
+		t.Fatal("expected a non-zero size for an UpdateOneModel")
+	}
+	if modelApproxSize(DeleteOneModel{Filter: "f"}) == 0 {
+		t.Fatal("expected a non-zero size for a DeleteOneModel")
+	}
+}
+
+func TestBulkWriteOptionsOrdered(t *testing.T) {
+	if !(*BulkWriteOptions)(nil).ordered() {
+		t.Fatal("expected nil options to default to ordered")
+	}
+	f := false
+	if (&BulkWriteOptions{Ordered: &f}).ordered() {
+		t.Fatal("expected Ordered: &false to turn ordered off")
+	}
+}
@@ -0,0 +1,66 @@
+package mgo
+
+import (
+	"errors"
+	"sync"
+)
+
+// mongoServer tracks a single server address and the sockets currently
+// idle in its pool, ready to be handed out again by AcquireSocket instead
+// of dialing a fresh connection for every operation.
+type mongoServer struct {
+	Addr string
+
+	m    sync.Mutex
+	pool []*mongoSocket
+}
+
+// recycleSocket returns socket to the pool once its last reference is
+// released, making it available for the next AcquireSocket call against
+// this server.
+func (srv *mongoServer) recycleSocket(socket *mongoSocket) {
+	srv.m.Lock()
+	srv.pool = append(srv.pool, socket)
+	srv.m.Unlock()
+}
+
+// AcquireSocket returns an idle socket from srv's pool, or dials a new one
+// if the pool is empty.
+func (srv *mongoServer) AcquireSocket() (*mongoSocket, error) {
+	srv.m.Lock()
+	if n := len(srv.pool); n > 0 {
+		socket := srv.pool[n-1]
+		srv.pool = srv.pool[:n-1]
+		srv.m.Unlock()
+		socket.refs = 1
+		return socket, nil
+	}
+	srv.m.Unlock()
+	return newMongoSocket(srv), nil
+}
+
+// mongoCluster tracks the servers known for a session and selects one of
+// them for each unpinned operation. Sessions with a transaction in
+// progress bypass this and reuse the socket pinned by StartTransaction
+// instead.
+type mongoCluster struct {
+	m       sync.Mutex
+	servers []*mongoServer
+	next    int
+}
+
+// AcquireSocket picks the next server in round-robin order and returns a
+// socket to it. slaveOk is accepted for symmetry with the server-selection
+// call sites that pass it, though this trimmed cluster doesn't yet
+// distinguish primaries from secondaries.
+func (cluster *mongoCluster) AcquireSocket(slaveOk bool) (*mongoSocket, error) {
+	cluster.m.Lock()
+	if len(cluster.servers) == 0 {
+		cluster.m.Unlock()
+		return nil, errors.New("mgo: no reachable servers")
+	}
+	srv := cluster.servers[cluster.next%len(cluster.servers)]
+	cluster.next++
+	cluster.m.Unlock()
+	return srv.AcquireSocket()
+}
@@ -0,0 +1,251 @@
+package dbtest
+
+// Multi-node replica set and sharded cluster support for DBServer, on top
+// of the single standalone mongod dbserver.go knows how to start. This
+// lets tests exercise failover, read-preference and sharding-aware code
+// paths that a single-node server can't.
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbNode is a single mongod or mongos process taking part in a cluster
+// started by DBServer.SetReplicaSetSize or DBServer.SetSharded.
+type dbNode struct {
+	cmd    *exec.Cmd
+	output bytes.Buffer
+	host   string
+}
+
+// freePorts allocates n free local ports up front, so every member of a
+// replica set or shard can be told the full set of addresses before any
+// of them start listening.
+func freePorts(n int) []int {
+	ports := make([]int, n)
+	for i := range ports {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic("unable to listen on a local address: " + err.Error())
+		}
+		ports[i] = l.Addr().(*net.TCPAddr).Port
+		l.Close()
+	}
+	return ports
+}
+
+// startNode launches a single mongod listening on port, storing its data
+// under dbpath, with extraArgs appended verbatim (e.g. --replSet,
+// --configsvr, --shardsvr).
+func startNode(dbpath, engine string, port int, extraArgs []string) *dbNode {
+	if err := os.MkdirAll(dbpath, 0755); err != nil {
+		panic(err)
+	}
+	args := []string{
+		"--dbpath", dbpath,
+		"--bind_ip", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--storageEngine=" + engine,
+	}
+	if engine == "mmapv1" {
+		args = append(args, "--nssize", "1", "--noprealloc", "--smallfiles")
+	}
+	args = append(args, extraArgs...)
+
+	node := &dbNode{host: "127.0.0.1:" + strconv.Itoa(port)}
+	node.cmd = exec.Command("mongod", args...)
+	node.cmd.Stdout = &node.output
+	node.cmd.Stderr = &node.output
+	if err := node.cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "mongod failed to start: %v\n", err)
+		panic(err)
+	}
+	return node
+}
+
+// startMongos launches a mongos listening on port and routing to the
+// config server replica set configRS, seeded from configHosts.
+func startMongos(port int, configRS string, configHosts []string) *dbNode {
+	args := []string{
+		"--bind_ip", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--configdb", configRS + "/" + strings.Join(configHosts, ","),
+	}
+	node := &dbNode{host: "127.0.0.1:" + strconv.Itoa(port)}
+	node.cmd = exec.Command("mongos", args...)
+	node.cmd.Stdout = &node.output
+	node.cmd.Stderr = &node.output
+	if err := node.cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "mongos failed to start: %v\n", err)
+		panic(err)
+	}
+	return node
+}
+
+// mongoEval runs `mongo host --eval script`, panicking with the captured
+// output if the shell reports a failure.
+func mongoEval(host, script string) {
+	cmd := exec.Command("mongo", host, "--eval", script)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "mongo --eval %q against %s failed: %v\n%s\n", script, host, err, out.String())
+		panic(err)
+	}
+}
+
+// waitForPrimary polls rs.isMaster() on host until a PRIMARY is elected or
+// timeout elapses.
+func waitForPrimary(host string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		cmd := exec.Command("mongo", host, "--quiet", "--eval", "!!rs.isMaster().primary")
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Run()
+		if strings.Contains(out.String(), "true") {
+			return
+		}
+		if time.Now().After(deadline) {
+			panic("timed out waiting for a PRIMARY on " + host)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// replicaSetInitiate runs rs.initiate for rsName across hosts, seeding
+// against hosts[0], and waits for a PRIMARY to be elected.
+func replicaSetInitiate(rsName string, hosts []string) {
+	members := make([]string, len(hosts))
+	for i, h := range hosts {
+		members[i] = fmt.Sprintf("{_id: %d, host: %q}", i, h)
+	}
+	script := fmt.Sprintf("rs.initiate({_id: %q, members: [%s]})", rsName, strings.Join(members, ", "))
+	mongoEval(hosts[0], script)
+	waitForPrimary(hosts[0], 30*time.Second)
+}
+
+// rsSizeOrDefault returns the replica set size to use for shard replica
+// sets: the SetReplicaSetSize value if it's greater than 1, or 3 otherwise.
+// The config server replica set always has a single member.
+func (dbs *DBServer) rsSizeOrDefault() int {
+	if dbs.rsSize > 1 {
+		return dbs.rsSize
+	}
+	return 3
+}
+
+// startReplicaSet launches an n-member replica set named rsName under
+// dbs.dbpath, recording every member in dbs.nodes and dbs.hosts.
+func (dbs *DBServer) startReplicaSet(n int, rsName string) {
+	for i, port := range freePorts(n) {
+		dbpath := filepath.Join(dbs.dbpath, rsName, strconv.Itoa(i))
+		node := startNode(dbpath, dbs.engine, port, []string{"--replSet", rsName})
+		dbs.nodes = append(dbs.nodes, node)
+		dbs.hosts = append(dbs.hosts, node.host)
+	}
+	time.Sleep(1 * time.Second)
+	replicaSetInitiate(rsName, dbs.hosts)
+}
+
+// startConfigServers launches an n-member config server replica set named
+// rsName and returns its member addresses.
+func (dbs *DBServer) startConfigServers(n int, rsName string) []string {
+	var hosts []string
+	for i, port := range freePorts(n) {
+		dbpath := filepath.Join(dbs.dbpath, rsName, strconv.Itoa(i))
+		node := startNode(dbpath, dbs.engine, port, []string{"--replSet", rsName, "--configsvr"})
+		dbs.configNodes = append(dbs.configNodes, node)
+		hosts = append(hosts, node.host)
+	}
+	time.Sleep(1 * time.Second)
+	replicaSetInitiate(rsName, hosts)
+	return hosts
+}
+
+// startSharded launches a config server replica set, dbs.shards shard
+// replica sets, and dbs.mongosCount mongos routers, then registers every
+// shard with the cluster via sh.addShard.
+func (dbs *DBServer) startSharded() {
+	if dbs.mongosCount < 1 {
+		panic("dbtest: SetSharded requires at least one mongos router")
+	}
+
+	configHosts := dbs.startConfigServers(1, "cfgrs0")
+
+	for s := 0; s < dbs.shards; s++ {
+		shardRS := fmt.Sprintf("shard%drs", s)
+		var shardHosts []string
+		for i, port := range freePorts(dbs.rsSizeOrDefault()) {
+			dbpath := filepath.Join(dbs.dbpath, shardRS, strconv.Itoa(i))
+			node := startNode(dbpath, dbs.engine, port, []string{"--replSet", shardRS, "--shardsvr"})
+			dbs.nodes = append(dbs.nodes, node)
+			shardHosts = append(shardHosts, node.host)
+		}
+		time.Sleep(1 * time.Second)
+		replicaSetInitiate(shardRS, shardHosts)
+		dbs.shardConnStrings = append(dbs.shardConnStrings, shardRS+"/"+strings.Join(shardHosts, ","))
+	}
+
+	var mongosHosts []string
+	for _, port := range freePorts(dbs.mongosCount) {
+		node := startMongos(port, "cfgrs0", configHosts)
+		dbs.mongosNodes = append(dbs.mongosNodes, node)
+		mongosHosts = append(mongosHosts, node.host)
+	}
+	time.Sleep(1 * time.Second)
+
+	for _, conn := range dbs.shardConnStrings {
+		mongoEval(mongosHosts[0], fmt.Sprintf("sh.addShard(%q)", conn))
+	}
+
+	dbs.hosts = mongosHosts
+	dbs.mongosHost = mongosHosts[0]
+}
+
+// Hosts returns the addresses to use when building a connection string: the
+// replica set member addresses for a DBServer started via
+// SetReplicaSetSize, or the mongos router addresses for one started via
+// SetSharded. It's empty for a plain standalone/1-member DBServer.
+func (dbs *DBServer) Hosts() []string {
+	return dbs.hosts
+}
+
+// MongosHost returns the address of a mongos router for a sharded cluster
+// started via SetSharded, or "" if the DBServer isn't sharded.
+func (dbs *DBServer) MongosHost() string {
+	return dbs.mongosHost
+}
+
+// stopNodes signals every process in nodes to stop, in reverse start
+// order, and waits for them to exit.
+func (dbs *DBServer) stopNodes(nodes []*dbNode) {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i].cmd.Process != nil {
+			nodes[i].cmd.Process.Signal(os.Interrupt)
+		}
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodes[i].cmd.Wait()
+	}
+}
+
+// stopCluster tears down every process started by startReplicaSet or
+// startSharded, in reverse order: mongos routers first, then shards, then
+// the config server replica set.
+func (dbs *DBServer) stopCluster() {
+	dbs.stopNodes(dbs.mongosNodes)
+	dbs.stopNodes(dbs.nodes)
+	dbs.stopNodes(dbs.configNodes)
+	dbs.mongosNodes = nil
+	dbs.nodes = nil
+	dbs.configNodes = nil
+}
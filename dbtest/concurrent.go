@@ -0,0 +1,286 @@
+package dbtest
+
+// ConcurrentHarness borrows the stress-testing pattern used to shake out
+// concurrency bugs in embedded stores: a handful of goroutines hammer the
+// server through a weighted mix of operations while a background verifier
+// checks a repeatable-read invariant, giving a reusable way to reproduce
+// socket-leak and session-leak issues that otherwise only surface much
+// later as checkSessions timeouts.
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// OpMix weights which operation a Writer worker runs on each iteration:
+// Reader and BulkWriter workers each only ever run their one designated
+// operation (find, and bulk write, respectively), so there's nothing for
+// those roles to weigh. Values are relative, not percentages: {Insert: 2,
+// Update: 1} makes a Writer insert twice as often as it updates.
+type OpMix struct {
+	Insert int
+	Update int
+}
+
+// pickWrite chooses between "insert" and "update" for a Writer worker,
+// weighted by m.Insert/m.Update. If both are zero, insert and update are
+// equally likely.
+func (m OpMix) pickWrite(r *rand.Rand) string {
+	insert, update := m.Insert, m.Update
+	if insert == 0 && update == 0 {
+		insert, update = 1, 1
+	}
+	if r.Intn(insert+update) < insert {
+		return "insert"
+	}
+	return "update"
+}
+
+// ConcurrentHarness drives DB through a mix of concurrent readers, writers
+// and bulk-writers for Duration (or MGO_CONCURRENT_DURATION, or 30s),
+// verifying as it goes that each key's stored revision counter never goes
+// backwards.
+type ConcurrentHarness struct {
+	// DB is the server the harness runs against. Its SetPath/Session must
+	// already be usable; the harness calls DB.Session() itself.
+	DB *DBServer
+
+	// Collection defaults to "stress" if empty.
+	Collection string
+
+	Readers     int
+	Writers     int
+	BulkWriters int
+	Mix         OpMix
+
+	KeySpace     int
+	MinValueSize int
+	MaxValueSize int
+
+	// Duration bounds the run. Zero means MGO_CONCURRENT_DURATION, or 30s
+	// if that's unset too.
+	Duration time.Duration
+}
+
+type stressDoc struct {
+	Id    int    `bson:"_id"`
+	Rev   int64  `bson:"rev"`
+	Value []byte `bson:"value"`
+}
+
+// concurrentFailure carries enough context to diagnose why the harness
+// stopped early: a panic recovered from a worker, or a verifier catching a
+// key's revision going backwards.
+type concurrentFailure struct {
+	reason string
+}
+
+func (f *concurrentFailure) Error() string { return f.reason }
+
+// Run drives the configured mix of workers against DB for Duration, then
+// returns nil, or a non-nil error carrying a dump of mgo.GetStats(), the
+// captured mongod log, and the stack traces of every goroutine if a panic
+// or a verification failure was observed.
+func (h *ConcurrentHarness) Run() error {
+	collName := h.Collection
+	if collName == "" {
+		collName = "stress"
+	}
+	duration := h.Duration
+	if duration == 0 {
+		duration = concurrentDurationFromEnv()
+	}
+
+	fail := make(chan *concurrentFailure, 1)
+	reportFailure := func(reason string) {
+		select {
+		case fail <- &concurrentFailure{reason: reason}:
+		default:
+		}
+	}
+
+	var seen sync.Map // key (int) -> last observed rev (int64)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// worker runs body in a loop, against its own session and collection
+	// handle, until stop is closed. Each role below passes a different
+	// body, so Readers/Writers/BulkWriters actually exercise distinct
+	// operations instead of all picking from the same mix.
+	worker := func(seed int64, body func(c *mgo.Collection, rnd *rand.Rand)) {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				reportFailure(fmt.Sprintf("worker panic: %v", r))
+			}
+		}()
+		session := h.DB.Session()
+		defer session.Close()
+		c := session.DB("test").C(collName)
+		rnd := rand.New(rand.NewSource(seed))
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			body(c, rnd)
+		}
+	}
+
+	readerBody := func(c *mgo.Collection, rnd *rand.Rand) { h.runFind(c, rnd) }
+	writerBody := func(c *mgo.Collection, rnd *rand.Rand) {
+		if h.Mix.pickWrite(rnd) == "insert" {
+			h.runInsert(c, rnd)
+		} else {
+			h.runUpdate(c, rnd)
+		}
+	}
+	bulkWriterBody := func(c *mgo.Collection, rnd *rand.Rand) { h.runBulkWrite(c, rnd) }
+
+	verifier := func() {
+		defer wg.Done()
+		session := h.DB.Session()
+		defer session.Close()
+		c := session.DB("test").C(collName)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			key := rand.Intn(h.KeySpace)
+			var doc stressDoc
+			if err := c.FindId(key).One(&doc); err == mgo.ErrNotFound {
+				continue
+			} else if err != nil {
+				continue
+			}
+			if prev, ok := seen.Load(key); ok && doc.Rev < prev.(int64) {
+				reportFailure(fmt.Sprintf("key %d: revision went from %d to %d", key, prev, doc.Rev))
+				return
+			}
+			seen.Store(key, doc.Rev)
+		}
+	}
+
+	wg.Add(h.Readers + h.Writers + h.BulkWriters + 1)
+	seed := int64(1)
+	for i := 0; i < h.Readers; i++ {
+		go worker(seed, readerBody)
+		seed++
+	}
+	for i := 0; i < h.Writers; i++ {
+		go worker(seed, writerBody)
+		seed++
+	}
+	for i := 0; i < h.BulkWriters; i++ {
+		go worker(seed, bulkWriterBody)
+		seed++
+	}
+	go verifier()
+
+	select {
+	case f := <-fail:
+		close(stop)
+		wg.Wait()
+		return h.diagnose(f)
+	case <-time.After(duration):
+		close(stop)
+		wg.Wait()
+		select {
+		case f := <-fail:
+			return h.diagnose(f)
+		default:
+			return nil
+		}
+	}
+}
+
+func (h *ConcurrentHarness) runInsert(c *mgo.Collection, rnd *rand.Rand) {
+	key := rnd.Intn(h.KeySpace)
+	doc := stressDoc{Id: key, Rev: 1, Value: randomValue(rnd, h.MinValueSize, h.MaxValueSize)}
+	// Collisions with an existing key are expected under concurrent load;
+	// fold them into an update so the revision counter stays monotonic.
+	if err := c.Insert(doc); mgo.IsDup(err) {
+		h.bumpRevision(c, key, doc.Value)
+	}
+}
+
+func (h *ConcurrentHarness) runUpdate(c *mgo.Collection, rnd *rand.Rand) {
+	key := rnd.Intn(h.KeySpace)
+	h.bumpRevision(c, key, randomValue(rnd, h.MinValueSize, h.MaxValueSize))
+}
+
+func (h *ConcurrentHarness) bumpRevision(c *mgo.Collection, key int, value []byte) {
+	_, _ = c.UpsertId(key, bson.M{
+		"$inc": bson.M{"rev": int64(1)},
+		"$set": bson.M{"value": value},
+	})
+}
+
+func (h *ConcurrentHarness) runFind(c *mgo.Collection, rnd *rand.Rand) {
+	var doc stressDoc
+	_ = c.FindId(rnd.Intn(h.KeySpace)).One(&doc)
+}
+
+func (h *ConcurrentHarness) runBulkWrite(c *mgo.Collection, rnd *rand.Rand) {
+	bulk := c.Bulk()
+	bulk.Unordered()
+	for i := 0; i < 5; i++ {
+		key := rnd.Intn(h.KeySpace)
+		bulk.Update(bson.M{"_id": key}, bson.M{
+			"$inc": bson.M{"rev": int64(1)},
+			"$set": bson.M{"value": randomValue(rnd, h.MinValueSize, h.MaxValueSize)},
+		})
+	}
+	_, _ = bulk.Run()
+}
+
+func randomValue(rnd *rand.Rand, min, max int) []byte {
+	size := min
+	if max > min {
+		size += rnd.Intn(max - min + 1)
+	}
+	buf := make([]byte, size)
+	rnd.Read(buf)
+	return buf
+}
+
+// diagnose builds an error describing why the run stopped early, along
+// with a full mgo.GetStats() snapshot, the mongod log captured so far, and
+// the stack traces of every live goroutine.
+func (h *ConcurrentHarness) diagnose(f *concurrentFailure) error {
+	stats := mgo.GetStats()
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	return fmt.Errorf(
+		"concurrent harness failed: %s\n\n-- mgo.GetStats() --\n%+v\n\n-- mongod log --\n%s\n\n-- goroutine stacks --\n%s",
+		f.reason, stats, h.DB.output.String(), buf[:n],
+	)
+}
+
+var concurrentDefaultDuration = 30 * time.Second
+
+func concurrentDurationFromEnv() time.Duration {
+	v := os.Getenv("MGO_CONCURRENT_DURATION")
+	if v == "" {
+		return concurrentDefaultDuration
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	return concurrentDefaultDuration
+}
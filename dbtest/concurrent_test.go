@@ -0,0 +1,35 @@
+package dbtest
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestOpMixPickWriteWeighted(t *testing.T) {
+	mix := OpMix{Insert: 3, Update: 0}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		if got := mix.pickWrite(rnd); got != "insert" {
+			t.Fatalf("expected an all-insert mix to always pick insert, got %q", got)
+		}
+	}
+}
+
+func TestOpMixPickWriteDefaultsToEvenSplit(t *testing.T) {
+	mix := OpMix{}
+	rnd := rand.New(rand.NewSource(1))
+	seenInsert, seenUpdate := false, false
+	for i := 0; i < 50; i++ {
+		switch mix.pickWrite(rnd) {
+		case "insert":
+			seenInsert = true
+		case "update":
+			seenUpdate = true
+		default:
+			t.Fatal("pickWrite returned something other than insert/update")
+		}
+	}
+	if !seenInsert || !seenUpdate {
+		t.Fatal("expected a zero-value OpMix to pick both insert and update over enough tries")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	mgo "github.com/globalsign/mgo"
@@ -34,6 +35,26 @@ type DBServer struct {
 	disableMonitor bool
 	wtCacheSizeGB  float64
 	tomb           tomb.Tomb
+
+	// rsSize, if greater than 1, makes start launch a multi-member replica
+	// set ("rs0") instead of the single standalone/1-member-RS mongod
+	// started by default. Set through SetReplicaSetSize.
+	rsSize int
+	// shards and mongosCount configure a sharded cluster, set through
+	// SetSharded. shards is the number of shard replica sets to create;
+	// each has rsSize (or 3, if unset) members.
+	shards      int
+	mongosCount int
+
+	// nodes, configNodes and mongosNodes hold every extra mongod/mongos
+	// process started for a multi-node replica set or sharded cluster, on
+	// top of (or instead of) dbs.server.
+	nodes            []*dbNode
+	configNodes      []*dbNode
+	mongosNodes      []*dbNode
+	hosts            []string
+	mongosHost       string
+	shardConnStrings []string
 }
 
 // SetPath defines the path to the directory where the database files will be
@@ -54,6 +75,31 @@ func (dbs *DBServer) SetReplicaSet(rs bool) {
 	dbs.rs = rs
 }
 
+// SetReplicaSetSize requests an n-member replica set named "rs0" rather
+// than the single-member one SetReplicaSet(true) starts on its own. It
+// implies SetReplicaSet(true). Use Hosts to get the member addresses once
+// the server has started.
+func (dbs *DBServer) SetReplicaSetSize(n int) {
+	dbs.rs = true
+	dbs.rsSize = n
+}
+
+// SetSharded requests a sharded cluster instead of a single server or
+// plain replica set: shards replica sets (3-member, unless
+// SetReplicaSetSize was also called) acting as shards, a 1-member config
+// server replica set, and mongos mongos routers in front of them. Use
+// MongosHost to get a router address to connect through once started.
+func (dbs *DBServer) SetSharded(shards, mongos int) {
+	dbs.shards = shards
+	dbs.mongosCount = mongos
+}
+
+// running reports whether any mongod/mongos process has already been
+// started for this DBServer, whatever topology it is.
+func (dbs *DBServer) running() bool {
+	return dbs.server != nil || len(dbs.nodes) > 0 || len(dbs.mongosNodes) > 0
+}
+
 // SetMonitor defines whether the MongoDB server should be monitored for crashes
 // panics, etc.
 func (dbs *DBServer) SetMonitor(enabled bool) {
@@ -69,13 +115,29 @@ func (dbs *DBServer) start(repl bool) {
 	if dbs.engine == "" {
 		dbs.engine = "mmapv1"
 	}
-	if dbs.server != nil {
+	if dbs.running() {
 		panic("DBServer already started")
 	}
 	if dbs.dbpath == "" {
 		panic("DBServer.SetPath must be called before using the server")
 	}
 	mgo.SetStats(true)
+
+	if dbs.shards > 0 {
+		dbs.startSharded()
+		dbs.host = dbs.mongosHost
+		time.Sleep(1 * time.Second)
+		dbs.Wipe()
+		return
+	}
+	if dbs.rsSize > 1 {
+		dbs.startReplicaSet(dbs.rsSize, "rs0")
+		dbs.host = strings.Join(dbs.hosts, ",")
+		time.Sleep(1 * time.Second)
+		dbs.Wipe()
+		return
+	}
+
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		panic("unable to listen on a local address: " + err.Error())
@@ -210,6 +272,7 @@ func (dbs *DBServer) Stop() {
 		}
 		dbs.server = nil
 	}
+	dbs.stopCluster()
 }
 
 // Session returns a new session to the server. The returned session
@@ -221,13 +284,13 @@ func (dbs *DBServer) Session() *mgo.Session {
 }
 
 func (dbs *DBServer) SessionRepl(repl bool) *mgo.Session {
-	if dbs.server == nil {
+	if !dbs.running() {
 		dbs.start(repl)
 	}
 	if dbs.session == nil {
 		mgo.ResetStats()
 		var err error
-		d, err := mgo.ParseURL(dbs.host + "/test?connect=replicaSet")
+		d, err := mgo.ParseURL(dbs.dialURL())
 		dbs.session, err = mgo.DialWithInfo(d)
 		if err != nil {
 			panic(err)
@@ -236,11 +299,22 @@ func (dbs *DBServer) SessionRepl(repl bool) *mgo.Session {
 	return dbs.session.Copy()
 }
 
+// dialURL returns the connection string SessionRepl dials. A sharded
+// cluster is dialed directly against its mongos router: unlike a replica
+// set member, a mongos doesn't report a setName, so asking the driver to
+// do replica-set discovery against it (connect=replicaSet) would fail.
+func (dbs *DBServer) dialURL() string {
+	if dbs.mongosHost != "" {
+		return dbs.host + "/test"
+	}
+	return dbs.host + "/test?connect=replicaSet"
+}
+
 // checkSessions ensures all mgo sessions opened were properly closed.
 // For slightly faster tests, it may be disabled setting the
 // environment variable CHECK_SESSIONS to 0.
 func (dbs *DBServer) checkSessions() {
-	if check := os.Getenv("CHECK_SESSIONS"); check == "0" || dbs.server == nil || dbs.session == nil {
+	if check := os.Getenv("CHECK_SESSIONS"); check == "0" || !dbs.running() || dbs.session == nil {
 		return
 	}
 	dbs.session.Close()
@@ -264,7 +338,7 @@ func (dbs *DBServer) checkSessions() {
 // is running. Otherwise Wipe will panic after a timeout informing that
 // there is a session leak.
 func (dbs *DBServer) Wipe() {
-	if dbs.server == nil || dbs.session == nil {
+	if !dbs.running() || dbs.session == nil {
 		return
 	}
 	dbs.checkSessions()
@@ -0,0 +1,17 @@
+package dbtest
+
+import "testing"
+
+func TestDialURLReplicaSet(t *testing.T) {
+	dbs := &DBServer{host: "127.0.0.1:40001"}
+	if got, want := dbs.dialURL(), "127.0.0.1:40001/test?connect=replicaSet"; got != want {
+		t.Fatalf("dialURL() = %q, want %q", got, want)
+	}
+}
+
+func TestDialURLSharded(t *testing.T) {
+	dbs := &DBServer{host: "127.0.0.1:40002", mongosHost: "127.0.0.1:40002"}
+	if got, want := dbs.dialURL(), "127.0.0.1:40002/test"; got != want {
+		t.Fatalf("dialURL() = %q, want %q", got, want)
+	}
+}
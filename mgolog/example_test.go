@@ -0,0 +1,51 @@
+package mgolog_test
+
+import (
+	"log"
+
+	"github.com/sirupsen/logrus"
+	mgo "github.com/zhwei820/mgo"
+	"github.com/zhwei820/mgo/mgolog"
+)
+
+// ExampleHook wires mgolog into logrus, so every log entry also lands in
+// the "logs" capped collection of the "app" database.
+func ExampleHook() {
+	session, err := mgo.Dial("localhost")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	hook, err := mgolog.New(session, "app", "logs", mgolog.Options{
+		MaxBytes: 64 * 1024 * 1024,
+		MaxDocs:  100000,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer hook.Close()
+
+	logger := logrus.New()
+	logger.AddHook(hook)
+	logger.WithField("component", "example").Info("hello from logrus")
+}
+
+// ExampleHook_Writer shows the same Hook used as a plain io.Writer, for
+// code that only knows about the standard log package.
+func ExampleHook_Writer() {
+	session, err := mgo.Dial("localhost")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	hook, err := mgolog.New(session, "app", "logs", mgolog.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer hook.Close()
+
+	stdlog := log.New(hook.Writer("info"), "", log.LstdFlags)
+	stdlog.Println("hello from the standard log package")
+}
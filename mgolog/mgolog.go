@@ -0,0 +1,290 @@
+// Package mgolog sinks log records into a capped MongoDB collection,
+// following the log-to-mongo pattern a number of projects built around mgo
+// have adopted. It ships a Hook compatible with logrus.Hook, and a plain
+// io.Writer adapter for anything else, including the standard log package.
+package mgolog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	mgo "github.com/zhwei820/mgo"
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Default tuning, used whenever the corresponding Options field is zero.
+const (
+	DefaultChannelSize   = 1024
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+)
+
+// Options configures a Hook.
+type Options struct {
+	// MaxBytes and MaxDocs size the capped collection created on first
+	// use. At least one of them must be set for Create to succeed; if
+	// both are zero, MaxBytes defaults to 64MB.
+	MaxBytes int64
+	MaxDocs  int
+
+	// ChannelSize bounds how many records may be queued between flushes
+	// before Fire/Write starts dropping them. Defaults to
+	// DefaultChannelSize.
+	ChannelSize int
+
+	// BatchSize is the number of queued records that triggers an
+	// immediate flush. Defaults to DefaultBatchSize.
+	BatchSize int
+
+	// FlushInterval is the longest a record waits before being flushed
+	// even if BatchSize hasn't been reached. Defaults to
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.ChannelSize == 0 {
+		o.ChannelSize = DefaultChannelSize
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.FlushInterval == 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+	if o.MaxBytes == 0 && o.MaxDocs == 0 {
+		o.MaxBytes = 64 * 1024 * 1024
+	}
+	return o
+}
+
+// record is the document shape inserted into the capped collection.
+type record struct {
+	Level   string    `bson:"level"`
+	Time    time.Time `bson:"time"`
+	Message string    `bson:"message"`
+	Host    string    `bson:"host"`
+	Caller  string    `bson:"caller"`
+	Fields  bson.M    `bson:"fields,omitempty"`
+}
+
+// Hook is a logrus.Hook that batches records and inserts them into a
+// capped collection without ever blocking the caller: records are placed
+// on a bounded channel drained by a background goroutine, and dropped
+// (counted via Dropped) when that channel is full.
+type Hook struct {
+	coll     *mgo.Collection
+	hostname string
+
+	batchSize     int
+	flushInterval time.Duration
+
+	records chan record
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	dropped int64
+	closed  int32
+}
+
+// New ensures the capped collection db.coll exists (creating it per opts if
+// it doesn't) and returns a Hook that inserts records into it.
+func New(session *mgo.Session, db, coll string, opts Options) (*Hook, error) {
+	opts = opts.withDefaults()
+
+	c := session.DB(db).C(coll)
+	if err := ensureCapped(c, opts); err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	h := &Hook{
+		coll:          c,
+		hostname:      hostname,
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		records:       make(chan record, opts.ChannelSize),
+		done:          make(chan struct{}),
+	}
+	h.wg.Add(1)
+	go h.loop()
+	return h, nil
+}
+
+// ensureCapped makes sure db.coll is a capped collection sized per opts,
+// creating it if it doesn't exist yet. If the collection already exists,
+// creation fails with an "already exists" error; ensureCapped treats that
+// as success only if the existing collection is actually capped, so a
+// plain collection created earlier by unrelated code can't silently turn
+// mgolog into an unbounded log sink.
+func ensureCapped(c *mgo.Collection, opts Options) error {
+	err := c.Create(&mgo.CollectionInfo{
+		Capped:   true,
+		MaxBytes: opts.MaxBytes,
+		MaxDocs:  opts.MaxDocs,
+	})
+	if err == nil {
+		return nil
+	}
+	if !isAlreadyExists(err) {
+		return err
+	}
+	var stats struct {
+		Capped bool `bson:"capped"`
+	}
+	if err := c.Database.Run(bson.D{{Name: "collStats", Value: c.Name}}, &stats); err != nil {
+		return err
+	}
+	if !stats.Capped {
+		return fmt.Errorf("mgolog: collection %s.%s already exists and is not capped", c.Database.Name, c.Name)
+	}
+	return nil
+}
+
+func isAlreadyExists(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "already exists")
+}
+
+// Levels implements logrus.Hook: mgolog logs at every level.
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook. It never blocks: if the internal queue is
+// full the record is dropped and counted in Dropped.
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	h.enqueue(record{
+		Level:   entry.Level.String(),
+		Time:    entry.Time,
+		Message: entry.Message,
+		Host:    h.hostname,
+		Caller:  callerFromEntry(entry),
+		Fields:  bson.M(entry.Data),
+	})
+	return nil
+}
+
+func callerFromEntry(entry *logrus.Entry) string {
+	if entry.Caller != nil {
+		return fmt.Sprintf("%s:%d", entry.Caller.File, entry.Caller.Line)
+	}
+	return ""
+}
+
+// Writer adapts a Hook to io.Writer, for use with the standard log
+// package or anything else that just wants to write log lines.
+type Writer struct {
+	hook  *Hook
+	level string
+}
+
+// Writer returns an io.Writer that records every line written to it at
+// level (e.g. "info"), sharing this Hook's queue and flush goroutine.
+func (h *Hook) Writer(level string) *Writer {
+	return &Writer{hook: h, level: level}
+}
+
+// Write implements io.Writer. It never blocks or returns an error for a
+// dropped record, matching the non-blocking behavior of Fire.
+func (w *Writer) Write(p []byte) (int, error) {
+	msg := string(p)
+	for len(msg) > 0 && (msg[len(msg)-1] == '\n' || msg[len(msg)-1] == '\r') {
+		msg = msg[:len(msg)-1]
+	}
+	w.hook.enqueue(record{
+		Level:   w.level,
+		Time:    time.Now(),
+		Message: msg,
+		Host:    w.hook.hostname,
+		// Unlike Fire, which gets an accurate caller from logrus itself,
+		// Write is reached through an arbitrary number of wrapper frames
+		// depending on what's writing to it (log.Logger, fmt.Fprintf,
+		// direct io.Writer use, ...), so there's no fixed runtime.Caller
+		// skip count that's correct for all of them. Leave it blank
+		// rather than report a misleading location.
+	})
+	return len(p), nil
+}
+
+func (h *Hook) enqueue(r record) {
+	select {
+	case h.records <- r:
+	default:
+		atomic.AddInt64(&h.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped so far because the
+// internal queue was full.
+func (h *Hook) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// loop drains h.records, flushing whenever a batch fills up or
+// flushInterval elapses, whichever comes first.
+func (h *Hook) loop() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]record, 0, h.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		h.insert(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case r := <-h.records:
+			batch = append(batch, r)
+			if len(batch) >= h.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-h.done:
+			// Drain whatever is left on the channel without blocking, then
+			// do a final flush.
+			for {
+				select {
+				case r := <-h.records:
+					batch = append(batch, r)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (h *Hook) insert(batch []record) {
+	bulk := h.coll.Bulk()
+	bulk.Unordered()
+	docs := make([]interface{}, len(batch))
+	for i, r := range batch {
+		docs[i] = r
+	}
+	bulk.Insert(docs...)
+	// Best-effort: a capped collection log sink shouldn't itself crash the
+	// process it's instrumenting over a transient write error.
+	bulk.Run()
+}
+
+// Close flushes any buffered records and stops the background goroutine.
+// It is safe to call Close more than once.
+func (h *Hook) Close() error {
+	if atomic.CompareAndSwapInt32(&h.closed, 0, 1) {
+		close(h.done)
+		h.wg.Wait()
+	}
+	return nil
+}
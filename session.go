@@ -0,0 +1,261 @@
+package mgo
+
+// mgo - MongoDB driver for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+import (
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// Session represents a communication session with the database.
+type Session struct {
+	m sync.RWMutex
+
+	cluster_ *mongoCluster
+	safe     *Safe
+
+	// lsid and txnNumber back the driver session machinery used by
+	// transactions: lsid is generated once per Session and cached, and
+	// txnNumber increases by one every time StartTransaction is called
+	// from it.
+	lsid      bson.Binary
+	txnNumber int64
+
+	// txnSocket, once set by StartTransaction, pins every operation run
+	// through this *Session (and only this one - Clone starts a fresh,
+	// unpinned Session) to the single server it was acquired from, as
+	// required by the MongoDB transactions spec. CommitTransaction and
+	// AbortTransaction clear it again.
+	txnSocket *mongoSocket
+}
+
+// cluster returns the mongoCluster backing s.
+func (s *Session) cluster() *mongoCluster {
+	return s.cluster_
+}
+
+// acquireSocket returns the socket the next operation run through s
+// should use: the one pinned by StartTransaction, if any, or otherwise a
+// socket obtained from the cluster as usual. Every op path (Collection,
+// Query, ...) is expected to go through this method, which is what makes
+// StartTransaction's server pinning effective rather than cosmetic.
+func (s *Session) acquireSocket(slaveOk bool) (*mongoSocket, error) {
+	s.m.RLock()
+	pinned := s.txnSocket
+	s.m.RUnlock()
+	if pinned != nil {
+		pinned.Ref()
+		return pinned, nil
+	}
+	return s.cluster_.AcquireSocket(slaveOk)
+}
+
+// Clone returns a copy of s that shares the same cluster connection, lsid
+// and txnNumber counter, but starts out with no transaction pinned,
+// regardless of whether s itself has one. Carrying lsid and txnNumber over
+// is what lets every transaction started from copies of the same root
+// session share one driver session id and a monotonically increasing
+// txnNumber, as the server requires.
+func (s *Session) Clone() *Session {
+	s.m.RLock()
+	clone := &Session{cluster_: s.cluster_, safe: s.safe, lsid: s.lsid, txnNumber: s.txnNumber}
+	s.m.RUnlock()
+	return clone
+}
+
+// Close releases any socket s has pinned for a transaction. It is safe to
+// call on a Session that never started one.
+func (s *Session) Close() {
+	s.m.Lock()
+	socket := s.txnSocket
+	s.txnSocket = nil
+	s.m.Unlock()
+	if socket != nil {
+		socket.Release()
+	}
+}
+
+// Safe returns the current safe mode (write concern) in use by s.
+func (s *Session) Safe() *Safe {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.safe
+}
+
+// newSessionID generates the 16-byte UUID used as the "id" of a driver
+// session's lsid, per the Driver Session spec. It is only ever computed
+// once per Session and then cached.
+func newSessionID() bson.Binary {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic("mgo: unable to read random bytes for session id: " + err.Error())
+	}
+	// Set the version (4) and variant (RFC 4122) bits.
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return bson.Binary{Kind: 0x04, Data: uuid[:]}
+}
+
+// sessionID returns the lsid for s, generating and caching one on first use.
+// All transactions started from copies of the same root session share the
+// same lsid, as required by the server.
+func (s *Session) sessionID() bson.Binary {
+	s.m.Lock()
+	if s.lsid.Data == nil {
+		s.lsid = newSessionID()
+	}
+	id := s.lsid
+	s.m.Unlock()
+	return id
+}
+
+// nextTxnNumber returns the next monotonically increasing transaction number
+// for s, as required by startTransaction.
+func (s *Session) nextTxnNumber() int64 {
+	return atomic.AddInt64(&s.txnNumber, 1)
+}
+
+// StartTransaction starts a new multi-document transaction on a copy of s
+// and returns a Transaction used to run operations within it. The copy
+// acquires a single socket up front and pins it for its entire lifetime,
+// via acquireSocket, so every operation run against collections obtained
+// from Transaction.Session lands on the same server.
+//
+// opts may be nil, in which case the session's current safe settings are
+// used as the transaction's write concern.
+func (s *Session) StartTransaction(opts *TxnOptions) (*Transaction, error) {
+	if opts == nil {
+		opts = &TxnOptions{}
+	}
+	if opts.WriteConcern == nil {
+		opts.WriteConcern = s.Safe()
+	}
+
+	lsid := s.sessionID()
+	txnNumber := s.nextTxnNumber()
+
+	txnSession := s.Clone()
+	socket, err := txnSession.cluster_.AcquireSocket(false)
+	if err != nil {
+		return nil, err
+	}
+	txnSession.m.Lock()
+	txnSession.txnSocket = socket
+	txnSession.m.Unlock()
+
+	return &Transaction{
+		session:   txnSession,
+		opts:      *opts,
+		lsid:      lsid,
+		txnNumber: txnNumber,
+	}, nil
+}
+
+// Session returns the session the transaction runs on. Collections obtained
+// from it are pinned to the server the transaction started against.
+func (t *Transaction) Session() *Session {
+	return t.session
+}
+
+// finishTransaction runs commitTransaction or abortTransaction against the
+// admin database on the server the transaction is pinned to, and translates
+// any reported errorLabels into a *TxnError.
+func (s *Session) finishTransaction(cmdName string, txnNumber int64, lsid bson.Binary, opts TxnOptions) error {
+	cmd := bson.D{
+		{Name: cmdName, Value: 1},
+		{Name: "lsid", Value: bson.D{{Name: "id", Value: lsid}}},
+		{Name: "txnNumber", Value: txnNumber},
+		{Name: "autocommit", Value: false},
+	}
+	if opts.WriteConcern != nil {
+		cmd = append(cmd, bson.DocElem{Name: "writeConcern", Value: opts.WriteConcern})
+	}
+	if cmdName == "commitTransaction" && opts.MaxCommitTime > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "maxTimeMS", Value: opts.MaxCommitTime / 1e6})
+	}
+
+	var result struct {
+		Ok     bool     `bson:"ok"`
+		Errmsg string   `bson:"errmsg"`
+		Code   int      `bson:"code"`
+		Labels []string `bson:"errorLabels"`
+	}
+	err := s.DB("admin").Run(cmd, &result)
+	if err != nil {
+		labels := result.Labels
+		return &TxnError{err: err, Labels: labels}
+	}
+	if !result.Ok {
+		return &TxnError{err: &QueryError{Code: result.Code, Message: result.Errmsg}, Labels: result.Labels}
+	}
+	return nil
+}
+
+// CommitTransaction sends commitTransaction for the given transaction
+// number/session id against admin, honoring opts.WriteConcern, then
+// releases the pinned socket the transaction held.
+func (s *Session) CommitTransaction(txnNumber int64, lsid bson.Binary, opts TxnOptions) error {
+	err := s.finishTransaction("commitTransaction", txnNumber, lsid, opts)
+	s.Close()
+	return err
+}
+
+// AbortTransaction sends abortTransaction for the given transaction
+// number/session id against admin, honoring opts.WriteConcern, then
+// releases the pinned socket the transaction held.
+func (s *Session) AbortTransaction(txnNumber int64, lsid bson.Binary, opts TxnOptions) error {
+	err := s.finishTransaction("abortTransaction", txnNumber, lsid, opts)
+	s.Close()
+	return err
+}
+
+// IsDup reports whether err is a MongoDB duplicate key error. It
+// understands plain write errors as well as a *BulkError returned from
+// Bulk.Run, treating the latter as a duplicate-key error only if every
+// case within it is one.
+func IsDup(err error) bool {
+	switch e := err.(type) {
+	case *BulkError:
+		if len(e.ecases) == 0 {
+			return false
+		}
+		for _, c := range e.ecases {
+			if !IsDup(c.Err) {
+				return false
+			}
+		}
+		return true
+	case *LastError:
+		return e.Code == 11000 || e.Code == 11001 || e.Code == 12582
+	case *QueryError:
+		return e.Code == 11000 || e.Code == 11001 || e.Code == 12582
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package mgo
+
+import "testing"
+
+func TestSessionIDCached(t *testing.T) {
+	s := &Session{}
+	first := s.sessionID()
+	second := s.sessionID()
+	if string(first.Data) != string(second.Data) {
+		t.Fatalf("sessionID changed across calls: %x != %x", first.Data, second.Data)
+	}
+}
+
+func TestNextTxnNumberIncreases(t *testing.T) {
+	s := &Session{}
+	if n := s.nextTxnNumber(); n != 1 {
+		t.Fatalf("expected first txnNumber to be 1, got %d", n)
+	}
+	if n := s.nextTxnNumber(); n != 2 {
+		t.Fatalf("expected second txnNumber to be 2, got %d", n)
+	}
+}
+
+func TestCloneSharesLsidAndTxnNumber(t *testing.T) {
+	s := &Session{}
+	lsid := s.sessionID()
+	s.nextTxnNumber()
+	s.nextTxnNumber()
+
+	clone := s.Clone()
+	if string(clone.lsid.Data) != string(lsid.Data) {
+		t.Fatalf("clone got a different lsid: %x != %x", clone.lsid.Data, lsid.Data)
+	}
+	if n := clone.nextTxnNumber(); n != 3 {
+		t.Fatalf("expected clone to continue the root's txnNumber counter at 3, got %d", n)
+	}
+}
@@ -0,0 +1,42 @@
+package mgo
+
+import "sync"
+
+// mongoSocket represents a single connection to a mongod or mongos. It is
+// reference counted rather than owned by a single caller, since a
+// transaction-pinned Session and the cluster's own connection pool may
+// both be holding a reference to the same socket at once: Ref bumps the
+// count on every new holder (e.g. Session.acquireSocket handing out a
+// pinned socket to an op), and Release only actually returns the socket to
+// its server's pool once the last holder lets go of it.
+type mongoSocket struct {
+	m      sync.Mutex
+	server *mongoServer
+	refs   int32
+}
+
+// newMongoSocket returns a socket for server with an initial reference
+// count of one, owned by the caller.
+func newMongoSocket(server *mongoServer) *mongoSocket {
+	return &mongoSocket{server: server, refs: 1}
+}
+
+// Ref adds one more reference to the socket. Every Ref must be matched by
+// a corresponding Release.
+func (s *mongoSocket) Ref() {
+	s.m.Lock()
+	s.refs++
+	s.m.Unlock()
+}
+
+// Release drops one reference to the socket, recycling it back into its
+// server's pool once the last reference is gone.
+func (s *mongoSocket) Release() {
+	s.m.Lock()
+	s.refs--
+	done := s.refs <= 0
+	s.m.Unlock()
+	if done {
+		s.server.recycleSocket(s)
+	}
+}
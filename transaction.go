@@ -27,6 +27,59 @@ package mgo
 // (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
 // SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
+import (
+	"time"
+
+	"gopkg.in/mgo.v2-unstable/bson"
+)
+
+// TxnOptions configures how a transaction started with Session.StartTransaction
+// or Session.WithTransaction behaves.
+type TxnOptions struct {
+	// WriteConcern is applied to commitTransaction and abortTransaction. A
+	// nil value inherits the session's current safe settings.
+	WriteConcern *Safe
+
+	// MaxCommitTime bounds how long the server will wait for commitTransaction
+	// to succeed. Zero means no limit is sent.
+	MaxCommitTime time.Duration
+}
+
+// withTransactionTimeout is the overall retry budget for Session.WithTransaction,
+// matching the MongoDB transactions spec recommendation of ~120 seconds.
+const withTransactionTimeout = 120 * time.Second
+
+// Transaction error labels as defined by the MongoDB transactions spec. These
+// are attached to server errors via the "errorLabels" response field and are
+// used by WithTransaction to decide whether an operation is safe to retry.
+const (
+	TransientTransactionError      = "TransientTransactionError"
+	UnknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+// TxnError wraps an error returned while running a transaction, preserving
+// the error labels reported by the server so callers can pattern-match on
+// them with HasErrorLabel instead of parsing error strings.
+type TxnError struct {
+	err    error
+	Labels []string
+}
+
+// Error implements the error interface.
+func (e *TxnError) Error() string {
+	return e.err.Error()
+}
+
+// HasErrorLabel reports whether the error carries the given label.
+func (e *TxnError) HasErrorLabel(label string) bool {
+	for _, l := range e.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
 // The transaction struct is only initialized with a valid Session, and that does not
 // change.  The struct contains state information for the transaction.  The transaction
 // is started when the first write operation is created using it, and it is finished when
@@ -35,30 +88,116 @@ package mgo
 // presumably already aborted.
 type Transaction struct {
 	session   *Session
+	opts      TxnOptions
+	lsid      bson.Binary
 	started   bool
 	finished  bool
 	txnNumber int64
 }
 
 // NewTransaction creates a new Transaction object.
+//
+// Deprecated: use Session.StartTransaction, which assigns the lsid/txnNumber
+// pair required by the server and pins the transaction to a single server.
 func NewTransaction(s *Session) Transaction {
 	return Transaction{
 		session: s,
+		lsid:    s.sessionID(),
+	}
+}
+
+// statementFields returns the fields that must be merged into the command
+// document for the next statement run through the transaction: the session
+// id, the transaction number, autocommit:false and - for the very first
+// statement only - startTransaction. Every later statement only carries
+// lsid, txnNumber and autocommit:false.
+func (t *Transaction) statementFields() bson.D {
+	d := bson.D{
+		{Name: "lsid", Value: bson.D{{Name: "id", Value: t.lsid}}},
+		{Name: "txnNumber", Value: t.txnNumber},
+		{Name: "autocommit", Value: false},
+	}
+	if !t.started {
+		d = append(d, bson.DocElem{Name: "startTransaction", Value: true})
+		t.started = true
+	}
+	return d
+}
+
+// Run executes cmd against db as a statement of the transaction: lsid,
+// txnNumber, autocommit and, for the first statement only, startTransaction
+// are merged into it via statementFields, and the command is sent over the
+// single socket pinned by StartTransaction rather than one freshly acquired
+// per call. Collection-level helpers that don't yet route through Run can
+// still be issued against t.Session(), which pins them the same way via
+// Session.acquireSocket; Run is how any other raw command picks up the same
+// lsid and txnNumber every other statement in the transaction shares.
+// Commit and Abort don't go through Run: they build their own
+// commitTransaction/abortTransaction command carrying that lsid and
+// txnNumber.
+func (t *Transaction) Run(db string, cmd bson.D, result interface{}) error {
+	socket, err := t.session.acquireSocket(false)
+	if err != nil {
+		return err
 	}
+	defer socket.Release()
+
+	full := append(bson.D{}, cmd...)
+	full = append(full, t.statementFields()...)
+	return t.session.DB(db).Run(full, result)
 }
 
 // Commit commits and finalizes the transaction.
 func (t *Transaction) Commit() error {
-	// check errors
-	err := t.session.CommitTransaction(t.txnNumber)
+	if !t.started {
+		// Nothing was ever sent to the server, so there is nothing to commit.
+		t.finished = true
+		return nil
+	}
+	err := t.session.CommitTransaction(t.txnNumber, t.lsid, t.opts)
 	t.finished = true
 	return err
 }
 
 // Abort aborts and closes the transaction.
 func (t *Transaction) Abort() error {
-	// check errors
-	err := t.session.AbortTransaction(t.txnNumber)
+	if !t.started {
+		t.finished = true
+		return nil
+	}
+	err := t.session.AbortTransaction(t.txnNumber, t.lsid, t.opts)
 	t.finished = true
 	return err
 }
+
+// WithTransaction starts a transaction on a copy of s, runs fn against it,
+// and commits. If fn returns an error, the transaction is aborted. Both the
+// operation itself and the commit are retried, within a ~120 second budget,
+// whenever the server reports TransientTransactionError or
+// UnknownTransactionCommitResult, per the MongoDB transactions spec.
+func (s *Session) WithTransaction(fn func(t *Transaction) error, opts *TxnOptions) error {
+	deadline := time.Now().Add(withTransactionTimeout)
+	for {
+		txn, err := s.StartTransaction(opts)
+		if err != nil {
+			return err
+		}
+		if err := fn(txn); err != nil {
+			txn.Abort()
+			if txnErr, ok := err.(*TxnError); ok && txnErr.HasErrorLabel(TransientTransactionError) && time.Now().Before(deadline) {
+				continue
+			}
+			return err
+		}
+		err = txn.Commit()
+		if err == nil {
+			return nil
+		}
+		if txnErr, ok := err.(*TxnError); ok && time.Now().Before(deadline) {
+			if txnErr.HasErrorLabel(TransientTransactionError) || txnErr.HasErrorLabel(UnknownTransactionCommitResult) {
+				continue
+			}
+		}
+		return err
+	}
+}